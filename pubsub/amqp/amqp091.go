@@ -0,0 +1,42 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import amqp091 "github.com/rabbitmq/amqp091-go"
+
+// DriverAMQP091 is the maintained rabbitmq/amqp091-go fork of
+// streadway/amqp, and is the default transport for protocolVersion "0-9-1".
+const DriverAMQP091 = "amqp091"
+
+func init() {
+	Register(DriverAMQP091, amqp091Transport{})
+}
+
+type amqp091Transport struct{}
+
+func (amqp091Transport) Dial(host string) (ConnectionBroker, ChannelBroker, error) {
+	conn, err := amqp091.Dial(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+
+		return nil, nil, err
+	}
+
+	return conn, channel, nil
+}
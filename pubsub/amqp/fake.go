@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	"errors"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// DriverFake is an in-process, in-memory transport with no real network
+// broker behind it. It is useful for exercising a component built on top of pubsub/amqp, or scripting precise
+// reconnect/publisher-confirm/return scenarios, without a RabbitMQ or
+// AMQP 1.0 broker.
+const DriverFake = "fake"
+
+// ErrConnectionClosed is the error message a real amqp091 channel reports
+// when an operation is attempted after the underlying connection dropped;
+// InMemoryBroker.Publish returns it for a message whose body equals this
+// string, so tests can script a mid-operation connection failure.
+const ErrConnectionClosed = "channel/connection is not open"
+
+func init() {
+	Register(DriverFake, &FakeTransport{})
+}
+
+// FakeTransport is the Transport implementation for DriverFake. Each Dial
+// call returns a fresh InMemoryBroker acting as both the ConnectionBroker
+// and the ChannelBroker, matching how a single amqp091 connection/channel
+// pair is normally returned together.
+type FakeTransport struct{}
+
+func (t *FakeTransport) Dial(host string) (ConnectionBroker, ChannelBroker, error) {
+	b := NewInMemoryBroker()
+
+	return b, b, nil
+}
+
+// QosCall records one invocation of ChannelBroker.Qos, so a test can assert
+// it was applied with the configured prefetchCount/prefetchSize.
+type QosCall struct {
+	PrefetchCount int
+	PrefetchSize  int
+	Global        bool
+}
+
+// InMemoryBroker is a single-queue, in-process stand-in for an amqp091
+// connection+channel, used by this package's own tests and by
+// pubsub/rabbitmq's to exercise reconnect, publisher-confirm/return, and
+// dead-letter-adjacent metadata behavior without a real broker. A single
+// instance models one connection's worth of state: every Publish/Consume
+// call lands on or drains the same buffer, regardless of the exchange,
+// routing key, or queue name given, since the components built on top of
+// ChannelBroker only ever run one subscription per connection at a time.
+type InMemoryBroker struct {
+	Buffer chan amqp091.Delivery
+
+	ConnectCount int
+	CloseCount   int
+
+	ConfirmMode            bool
+	PublishTag             uint64
+	Confirms               chan amqp091.Confirmation
+	NotifyPublishCallCount int
+	Returns                chan amqp091.Return
+
+	// NextConfirmNack, when true, makes the next confirm-mode publish report
+	// a nack instead of an ack; it then resets itself back to false.
+	NextConfirmNack bool
+	// NextPublishUnroutable, when true, makes the next mandatory publish be
+	// reported back on the returns channel instead of delivered.
+	NextPublishUnroutable bool
+
+	LastExchangeKind     string
+	LastExchangeArgs     amqp091.Table
+	LastPublishHeaders   amqp091.Table
+	LastPublishPriority  uint8
+	LastQueueDeclareArgs amqp091.Table
+	LastConsumeArgs      amqp091.Table
+
+	// QueueDeclareArgs records every QueueDeclare call's args by queue name,
+	// for asserting on a specific queue (e.g. a retry queue) declared
+	// earlier than the last QueueDeclare call in the same test.
+	QueueDeclareArgs map[string]amqp091.Table
+
+	QosCalls []QosCall
+}
+
+// NewInMemoryBroker returns a ready-to-use InMemoryBroker with a small
+// buffered queue.
+func NewInMemoryBroker() *InMemoryBroker {
+	return &InMemoryBroker{
+		Buffer: make(chan amqp091.Delivery, 64),
+	}
+}
+
+func (b *InMemoryBroker) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b.QosCalls = append(b.QosCalls, QosCall{PrefetchCount: prefetchCount, PrefetchSize: prefetchSize, Global: global})
+
+	return nil
+}
+
+func (b *InMemoryBroker) Confirm(noWait bool) error {
+	b.ConfirmMode = true
+
+	return nil
+}
+
+func (b *InMemoryBroker) NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation {
+	b.NotifyPublishCallCount++
+	b.Confirms = confirm
+
+	return confirm
+}
+
+func (b *InMemoryBroker) NotifyReturn(c chan amqp091.Return) chan amqp091.Return {
+	b.Returns = c
+
+	return c
+}
+
+func (b *InMemoryBroker) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error {
+	if string(msg.Body) == ErrConnectionClosed {
+		return errors.New(ErrConnectionClosed)
+	}
+
+	b.PublishTag++
+	b.LastPublishHeaders = msg.Headers
+	b.LastPublishPriority = msg.Priority
+
+	if mandatory && b.NextPublishUnroutable {
+		b.NextPublishUnroutable = false
+		if b.Returns != nil {
+			b.Returns <- amqp091.Return{Exchange: exchange, RoutingKey: key, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+		}
+
+		return nil
+	}
+
+	b.Buffer <- amqp091.Delivery{Body: msg.Body, Acknowledger: FakeAcknowledger{}}
+
+	if b.ConfirmMode && b.Confirms != nil {
+		ack := !b.NextConfirmNack
+		b.NextConfirmNack = false
+		b.Confirms <- amqp091.Confirmation{DeliveryTag: b.PublishTag, Ack: ack}
+	}
+
+	return nil
+}
+
+func (b *InMemoryBroker) QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	b.LastQueueDeclareArgs = args
+
+	if b.QueueDeclareArgs == nil {
+		b.QueueDeclareArgs = map[string]amqp091.Table{}
+	}
+	b.QueueDeclareArgs[name] = args
+
+	return amqp091.Queue{Name: name}, nil
+}
+
+func (b *InMemoryBroker) QueueBind(name string, key string, exchange string, noWait bool, args amqp091.Table) error {
+	return nil
+}
+
+func (b *InMemoryBroker) Consume(queue string, consumer string, autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	b.LastConsumeArgs = args
+
+	return b.Buffer, nil
+}
+
+func (b *InMemoryBroker) Nack(tag uint64, multiple bool, requeue bool) error {
+	return nil
+}
+
+func (b *InMemoryBroker) Ack(tag uint64, multiple bool) error {
+	return nil
+}
+
+func (b *InMemoryBroker) ExchangeDeclare(name string, kind string, durable bool, autoDelete bool, internal bool, noWait bool, args amqp091.Table) error {
+	b.LastExchangeKind = kind
+	b.LastExchangeArgs = args
+
+	return nil
+}
+
+func (b *InMemoryBroker) Close() error {
+	b.CloseCount++
+
+	return nil
+}
+
+// FakeAcknowledger is a no-op amqp091.Acknowledger for deliveries that
+// InMemoryBroker hands out, so a test can ack/nack them without a real
+// channel underneath.
+type FakeAcknowledger struct{}
+
+func (a FakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	return nil
+}
+
+func (a FakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return nil
+}
+
+func (a FakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return nil
+}
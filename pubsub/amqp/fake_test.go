@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupKnownDrivers(t *testing.T) {
+	for _, name := range []string{DriverAMQP091, DriverStreadway, DriverQpidProton, DriverFake} {
+		transport, err := Lookup(name)
+		assert.NoError(t, err)
+		assert.NotNil(t, transport)
+	}
+}
+
+func TestLookupUnknownDriver(t *testing.T) {
+	_, err := Lookup("does-not-exist")
+	assert.Error(t, err)
+}
+
+func TestFakeTransportPublishAndConsume(t *testing.T) {
+	transport := &FakeTransport{}
+
+	_, channel, err := transport.Dial("fake://")
+	assert.NoError(t, err)
+
+	_, err = channel.QueueDeclare("myqueue", true, false, false, false, nil)
+	assert.NoError(t, err)
+
+	err = channel.Publish("myqueue", "", false, false, amqp091.Publishing{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	deliveries, err := channel.Consume("myqueue", "", true, false, false, false, nil)
+	assert.NoError(t, err)
+
+	d := <-deliveries
+	assert.Equal(t, []byte("hello"), d.Body)
+}
+
+func TestInMemoryBrokerPublisherConfirms(t *testing.T) {
+	broker := NewInMemoryBroker()
+
+	err := broker.Confirm(false)
+	assert.NoError(t, err)
+
+	confirms := broker.NotifyPublish(make(chan amqp091.Confirmation, 1))
+
+	err = broker.Publish("", "myqueue", false, false, amqp091.Publishing{Body: []byte("hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, amqp091.Confirmation{DeliveryTag: 1, Ack: true}, <-confirms)
+
+	broker.NextConfirmNack = true
+	err = broker.Publish("", "myqueue", false, false, amqp091.Publishing{Body: []byte("world")})
+	assert.NoError(t, err)
+	assert.Equal(t, amqp091.Confirmation{DeliveryTag: 2, Ack: false}, <-confirms)
+}
+
+func TestInMemoryBrokerMandatoryPublishReturned(t *testing.T) {
+	broker := NewInMemoryBroker()
+	returns := broker.NotifyReturn(make(chan amqp091.Return, 1))
+
+	broker.NextPublishUnroutable = true
+	err := broker.Publish("myexchange", "mykey", true, false, amqp091.Publishing{Body: []byte("hello")})
+	assert.NoError(t, err)
+
+	ret := <-returns
+	assert.Equal(t, "myexchange", ret.Exchange)
+	assert.Equal(t, "mykey", ret.RoutingKey)
+
+	select {
+	case <-broker.Buffer:
+		t.Fatal("an unroutable mandatory publish must not be delivered")
+	default:
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	"context"
+	"fmt"
+
+	amqp1 "github.com/Azure/go-amqp"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// DriverQpidProton targets AMQP 1.0 brokers that speak the protocol the
+// qpid-proton library popularized, such as ActiveMQ Artemis or Azure
+// Service Bus's AMQP endpoint. It is selected by setting protocolVersion to
+// "1.0", or the driver metadata key directly.
+//
+// AMQP 1.0 has no wire-level concept of exchanges, bindings, or publisher
+// confirms/returns the way AMQP 0-9-1 does: routing is broker-managed and
+// addressed by name, and delivery settlement stands in for confirms. This
+// transport maps the rabbitmq component's 0-9-1-shaped ChannelBroker calls
+// onto the closest AMQP 1.0 equivalents, treating "exchange" and "queue"
+// names interchangeably as node addresses.
+const DriverQpidProton = "qpid-proton"
+
+func init() {
+	Register(DriverQpidProton, qpidProtonTransport{})
+}
+
+type qpidProtonTransport struct{}
+
+func (qpidProtonTransport) Dial(host string) (ConnectionBroker, ChannelBroker, error) {
+	conn, err := amqp1.Dial(context.Background(), host, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := conn.NewSession(context.Background(), nil)
+	if err != nil {
+		conn.Close()
+
+		return nil, nil, err
+	}
+
+	return qpidProtonConnection{conn}, &qpidProtonChannel{session: session}, nil
+}
+
+type qpidProtonConnection struct {
+	conn *amqp1.Conn
+}
+
+func (c qpidProtonConnection) Close() error {
+	return c.conn.Close()
+}
+
+// qpidProtonChannel adapts an AMQP 1.0 session to ChannelBroker. Topology
+// calls are no-ops: addresses are resolved by the broker, not declared by
+// the client. Senders/receivers are created lazily per address and cached
+// for the life of the session.
+type qpidProtonChannel struct {
+	session   *amqp1.Session
+	senders   map[string]*amqp1.Sender
+	receivers map[string]*amqp1.Receiver
+	confirm   chan amqp091.Confirmation
+}
+
+func (c *qpidProtonChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	// Credit-based flow control is negotiated per-receiver at link
+	// attachment time; there is no separate Qos call in AMQP 1.0. Credit is
+	// issued when Consume creates the receiver.
+	return nil
+}
+
+func (c *qpidProtonChannel) sender(address string) (*amqp1.Sender, error) {
+	if s, ok := c.senders[address]; ok {
+		return s, nil
+	}
+
+	s, err := c.session.NewSender(context.Background(), address, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.senders == nil {
+		c.senders = map[string]*amqp1.Sender{}
+	}
+	c.senders[address] = s
+
+	return s, nil
+}
+
+func (c *qpidProtonChannel) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error {
+	address := exchange
+	if address == "" {
+		address = key
+	}
+
+	s, err := c.sender(address)
+	if err != nil {
+		return err
+	}
+
+	m := &amqp1.Message{
+		Data:                  [][]byte{msg.Body},
+		ApplicationProperties: tableToProperties(msg.Headers),
+	}
+	if msg.ContentType != "" {
+		m.Properties = &amqp1.MessageProperties{ContentType: &msg.ContentType}
+	}
+
+	if err := s.Send(context.Background(), m, nil); err != nil {
+		return err
+	}
+
+	// AMQP 1.0's Send already settled the transfer synchronously above, so a
+	// successful return here is the confirmation: synthesize the Ack that a
+	// NotifyPublish caller is waiting for.
+	if c.confirm != nil {
+		c.confirm <- amqp091.Confirmation{Ack: true}
+	}
+
+	return nil
+}
+
+func (c *qpidProtonChannel) QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	// AMQP 1.0 queues are provisioned broker-side; there is no client
+	// declare verb. Report the requested name back so callers can keep
+	// using it as the Consume/Publish address.
+	return amqp091.Queue{Name: name}, nil
+}
+
+func (c *qpidProtonChannel) QueueBind(name string, key string, exchange string, noWait bool, args amqp091.Table) error {
+	// Routing between addresses is configured on the broker; nothing to do
+	// client-side.
+	return nil
+}
+
+func (c *qpidProtonChannel) Consume(queue string, consumer string, autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	r, err := c.session.NewReceiver(context.Background(), queue, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.receivers == nil {
+		c.receivers = map[string]*amqp1.Receiver{}
+	}
+	c.receivers[queue] = r
+
+	out := make(chan amqp091.Delivery)
+	go func() {
+		defer close(out)
+
+		for {
+			msg, err := r.Receive(context.Background(), nil)
+			if err != nil {
+				return
+			}
+
+			var body []byte
+			if len(msg.Data) > 0 {
+				body = msg.Data[0]
+			}
+
+			out <- amqp091.Delivery{
+				Body:         body,
+				Acknowledger: &qpidProtonAcknowledger{receiver: r, msg: msg},
+			}
+
+			if autoAck {
+				r.AcceptMessage(context.Background(), msg)
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (c *qpidProtonChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return fmt.Errorf("amqp: Nack by delivery tag is not supported over AMQP 1.0; settle via the per-delivery Acknowledger")
+}
+
+func (c *qpidProtonChannel) Ack(tag uint64, multiple bool) error {
+	return fmt.Errorf("amqp: Ack by delivery tag is not supported over AMQP 1.0; settle via the per-delivery Acknowledger")
+}
+
+func (c *qpidProtonChannel) ExchangeDeclare(name string, kind string, durable bool, autoDelete bool, internal bool, noWait bool, args amqp091.Table) error {
+	// No exchange concept in AMQP 1.0; addressing is flat.
+	return nil
+}
+
+func (c *qpidProtonChannel) Confirm(noWait bool) error {
+	// Every AMQP 1.0 send is already settled end-to-end by the link
+	// protocol; there is no separate opt-in confirm mode.
+	return nil
+}
+
+// NotifyPublish registers confirm to receive an Ack after every subsequent
+// successful Publish. Unlike AMQP 0-9-1, there is no broker-side nack here:
+// a failed Send is reported as a Publish error instead, so confirm only ever
+// carries Acks, mirroring a broker that never rejects a settled transfer.
+func (c *qpidProtonChannel) NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation {
+	c.confirm = confirm
+
+	return confirm
+}
+
+func (c *qpidProtonChannel) NotifyReturn(ret chan amqp091.Return) chan amqp091.Return {
+	close(ret)
+
+	return ret
+}
+
+func (c *qpidProtonChannel) Close() error {
+	return c.session.Close(context.Background())
+}
+
+// qpidProtonAcknowledger settles a single AMQP 1.0 delivery, mapping Ack to
+// an accept and Nack to a release (requeue) or reject (quarantine).
+type qpidProtonAcknowledger struct {
+	receiver *amqp1.Receiver
+	msg      *amqp1.Message
+}
+
+func (a *qpidProtonAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.receiver.AcceptMessage(context.Background(), a.msg)
+}
+
+func (a *qpidProtonAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	if requeue {
+		return a.receiver.ReleaseMessage(context.Background(), a.msg)
+	}
+
+	return a.receiver.RejectMessage(context.Background(), a.msg, nil)
+}
+
+func (a *qpidProtonAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func tableToProperties(t amqp091.Table) map[string]interface{} {
+	if t == nil {
+		return nil
+	}
+
+	out := make(map[string]interface{}, len(t))
+	for k, v := range t {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableToPropertiesNil(t *testing.T) {
+	assert.Nil(t, tableToProperties(nil))
+}
+
+func TestTableToProperties(t *testing.T) {
+	props := tableToProperties(amqp091.Table{"x-retry": int64(3)})
+
+	assert.Equal(t, map[string]interface{}{"x-retry": int64(3)}, props)
+}
+
+// TestNotifyPublishRegistersConfirmChannel guards against a regression where
+// NotifyPublish closed the channel it was handed instead of wiring it up to
+// receive an Ack once Publish next settles a send: every publisher-confirms
+// publish over this transport would then see a closed channel and fail with
+// a false-negative error despite the underlying AMQP 1.0 send succeeding.
+func TestNotifyPublishRegistersConfirmChannel(t *testing.T) {
+	c := &qpidProtonChannel{}
+	confirm := make(chan amqp091.Confirmation, 1)
+
+	got := c.NotifyPublish(confirm)
+
+	assert.Equal(t, confirm, got)
+	assert.Equal(t, confirm, c.confirm)
+
+	select {
+	case <-confirm:
+		t.Fatal("NotifyPublish must not close or write to the channel before a publish settles")
+	default:
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	streadway "github.com/streadway/amqp"
+)
+
+// DriverStreadway keeps the abandoned streadway/amqp driver available as an
+// opt-in transport for deployments that cannot move to amqp091-go yet.
+// New deployments should use DriverAMQP091.
+const DriverStreadway = "streadway"
+
+func init() {
+	Register(DriverStreadway, streadwayTransport{})
+}
+
+type streadwayTransport struct{}
+
+func (streadwayTransport) Dial(host string) (ConnectionBroker, ChannelBroker, error) {
+	conn, err := streadway.Dial(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+
+		return nil, nil, err
+	}
+
+	return conn, streadwayChannel{channel}, nil
+}
+
+// streadwayChannel adapts a *streadway.Channel to ChannelBroker, whose
+// methods are expressed in terms of amqp091 types.
+type streadwayChannel struct {
+	ch *streadway.Channel
+}
+
+func (s streadwayChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return s.ch.Qos(prefetchCount, prefetchSize, global)
+}
+
+func (s streadwayChannel) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error {
+	return s.ch.Publish(exchange, key, mandatory, immediate, streadway.Publishing{
+		Headers:         streadwayTable(msg.Headers),
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	})
+}
+
+func (s streadwayChannel) QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	q, err := s.ch.QueueDeclare(name, durable, autoDelete, exclusive, noWait, streadwayTable(args))
+
+	return amqp091.Queue{Name: q.Name, Messages: q.Messages, Consumers: q.Consumers}, err
+}
+
+func (s streadwayChannel) QueueBind(name string, key string, exchange string, noWait bool, args amqp091.Table) error {
+	return s.ch.QueueBind(name, key, exchange, noWait, streadwayTable(args))
+}
+
+func (s streadwayChannel) Consume(queue string, consumer string, autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	deliveries, err := s.ch.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, streadwayTable(args))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan amqp091.Delivery)
+	go func() {
+		defer close(out)
+		for d := range deliveries {
+			out <- amqp091.Delivery{
+				Headers:      amqp091Table(d.Headers),
+				ContentType:  d.ContentType,
+				Body:         d.Body,
+				Acknowledger: d.Acknowledger,
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (s streadwayChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return s.ch.Nack(tag, multiple, requeue)
+}
+
+func (s streadwayChannel) Ack(tag uint64, multiple bool) error {
+	return s.ch.Ack(tag, multiple)
+}
+
+func (s streadwayChannel) ExchangeDeclare(name string, kind string, durable bool, autoDelete bool, internal bool, noWait bool, args amqp091.Table) error {
+	return s.ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, streadwayTable(args))
+}
+
+func (s streadwayChannel) Confirm(noWait bool) error {
+	return s.ch.Confirm(noWait)
+}
+
+// NotifyPublish must be called at most once per channel lifetime, not once
+// per publish: streadway/amqp, like amqp091-go, appends every registered
+// listener to a list it only clears on channel close, so a fresh bridge and
+// forwarding goroutine per publish would leak both for the life of the
+// connection.
+func (s streadwayChannel) NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation {
+	bridge := make(chan streadway.Confirmation)
+	s.ch.NotifyPublish(bridge)
+
+	go func() {
+		for c := range bridge {
+			confirm <- amqp091.Confirmation{DeliveryTag: c.DeliveryTag, Ack: c.Ack}
+		}
+		close(confirm)
+	}()
+
+	return confirm
+}
+
+func (s streadwayChannel) NotifyReturn(c chan amqp091.Return) chan amqp091.Return {
+	bridge := make(chan streadway.Return)
+	s.ch.NotifyReturn(bridge)
+
+	go func() {
+		for r := range bridge {
+			c <- amqp091.Return{
+				ReplyCode:  r.ReplyCode,
+				ReplyText:  r.ReplyText,
+				Exchange:   r.Exchange,
+				RoutingKey: r.RoutingKey,
+				Body:       r.Body,
+			}
+		}
+		close(c)
+	}()
+
+	return c
+}
+
+func (s streadwayChannel) Close() error {
+	return s.ch.Close()
+}
+
+// streadwayTable and amqp091Table do a shallow copy between the two
+// libraries' (structurally identical) Table types; neither library's
+// Table nests anything richer than further Tables/slices in practice for
+// the headers this component sets.
+func streadwayTable(t amqp091.Table) streadway.Table {
+	if t == nil {
+		return nil
+	}
+
+	out := make(streadway.Table, len(t))
+	for k, v := range t {
+		out[k] = v
+	}
+
+	return out
+}
+
+func amqp091Table(t streadway.Table) amqp091.Table {
+	if t == nil {
+		return nil
+	}
+
+	out := make(amqp091.Table, len(t))
+	for k, v := range t {
+		out[k] = v
+	}
+
+	return out
+}
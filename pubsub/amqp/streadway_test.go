@@ -0,0 +1,34 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqp
+
+import (
+	"testing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreadwayTableNil(t *testing.T) {
+	assert.Nil(t, streadwayTable(nil))
+	assert.Nil(t, amqp091Table(nil))
+}
+
+func TestStreadwayTableRoundTrip(t *testing.T) {
+	in := amqp091.Table{"x-death-count": int64(2), "x-retry": "yes"}
+
+	out := amqp091Table(streadwayTable(in))
+
+	assert.Equal(t, amqp091.Table(in), out)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package amqp factors the AMQP connection/channel surface that
+// pubsub/rabbitmq depends on out of any one driver, so a single Dapr
+// component can front different AMQP-compatible brokers (RabbitMQ over
+// AMQP 0-9-1, or an AMQP 1.0 broker such as ActiveMQ Artemis or Azure
+// Service Bus) behind the same rabbitMQ implementation. It mirrors the
+// approach the Mainflux/absmach `brokers` package takes to swap NATS and
+// RabbitMQ behind one interface.
+package amqp
+
+import (
+	"fmt"
+	"sync"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// ConnectionBroker is the subset of a driver's connection type that callers
+// need: the ability to close it.
+type ConnectionBroker interface {
+	Close() error
+}
+
+// ChannelBroker is the subset of a driver's channel (or, for AMQP 1.0
+// drivers, session) type that callers need to publish, consume, and manage
+// topology.
+type ChannelBroker interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp091.Publishing) error
+	QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name string, key string, exchange string, noWait bool, args amqp091.Table) error
+	Consume(queue string, consumer string, autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error)
+	Nack(tag uint64, multiple bool, requeue bool) error
+	Ack(tag uint64, multiple bool) error
+	ExchangeDeclare(name string, kind string, durable bool, autoDelete bool, internal bool, noWait bool, args amqp091.Table) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation
+	NotifyReturn(c chan amqp091.Return) chan amqp091.Return
+	Close() error
+}
+
+// Transport dials a broker and returns its connection and channel.
+type Transport interface {
+	Dial(host string) (ConnectionBroker, ChannelBroker, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Transport{}
+)
+
+// Register makes a Transport available under name for Lookup. It is
+// intended to be called from each transport implementation's init().
+func Register(name string, transport Transport) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[name] = transport
+}
+
+// Lookup returns the Transport registered under name.
+func Lookup(name string) (Transport, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	transport, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("amqp: no transport registered for driver %q", name)
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,974 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rabbitmq implements a RabbitMQ pubsub component backed by the
+// maintained rabbitmq/amqp091-go driver.
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/dapr/components-contrib/pubsub"
+	rabbitmqamqp "github.com/dapr/components-contrib/pubsub/amqp"
+	"github.com/dapr/kit/logger"
+)
+
+const (
+	metadataHostKey              = "host"
+	metadataConsumerIDKey        = "consumerID"
+	metadataDurable              = "durable"
+	metadataDeleteWhenUnused     = "deletedWhenUnused"
+	metadataAutoAck              = "autoAck"
+	metadataRequeueInFailure     = "requeueInFailure"
+	metadataReconnectWaitSeconds = "reconnectWaitInSeconds"
+	metadataExchangeKind         = "exchangeKind"
+
+	// metadataDriver selects the pubsub/amqp transport by name directly
+	// (e.g. "amqp091", "streadway", "qpid-proton"), overriding whatever
+	// protocolVersion would otherwise select.
+	metadataDriver = "driver"
+
+	// metadataProtocolVersion picks a transport by AMQP protocol version:
+	// "0-9-1" (the default, RabbitMQ's native protocol) or "1.0" (AMQP 1.0
+	// brokers such as ActiveMQ Artemis or Azure Service Bus).
+	metadataProtocolVersion = "protocolVersion"
+	protocolVersion091      = "0-9-1"
+	protocolVersion10       = "1.0"
+
+	// metadataPublisherConfirms turns the publish channel into confirm mode so
+	// that Publish can verify the broker actually accepted a message.
+	metadataPublisherConfirms     = "publisherConfirms"
+	metadataPublishConfirmTimeout = "publishConfirmTimeout"
+
+	// metadataUseDelayedExchange declares the topic exchange with the
+	// rabbitmq_delayed_message_exchange plugin's "x-delayed-message" type so
+	// that per-message deliverAfter/deliverAt metadata is honored.
+	metadataUseDelayedExchange = "useDelayedExchange"
+	delayedExchangeKind        = "x-delayed-message"
+	delayedExchangeTypeArg     = "x-delayed-type"
+	delayHeader                = "x-delay"
+
+	// metadataDeliverAfter delays a single publish by a duration (e.g. "30s"),
+	// metadataDeliverAt delays it until an RFC3339 timestamp.
+	metadataDeliverAfter = "deliverAfter"
+	metadataDeliverAt    = "deliverAt"
+
+	// metadataEnableDeadLetter turns on native dead-lettering of poison
+	// messages instead of nacking them back onto the primary queue forever.
+	metadataEnableDeadLetter     = "enableDeadLetter"
+	metadataDeadLetterExchange   = "deadLetterExchange"
+	metadataDeadLetterRoutingKey = "deadLetterRoutingKey"
+	metadataMaxDeliveryAttempts  = "maxDeliveryAttempts"
+	// metadataRetryTTL controls how long an in-budget failed message waits on
+	// the per-queue retry queue before it dead-letters back to the primary
+	// exchange for redelivery; it defaults to 0 (redeliver immediately). Every
+	// in-budget failure goes through this retry queue when enableDeadLetter
+	// is set, not just when retryTTL is given explicitly, since that's the
+	// only way deliveryAttempts (x-death) actually accumulates towards
+	// maxDeliveryAttempts.
+	metadataRetryTTL = "retryTTL"
+
+	// metadataPrefetchCount/metadataPrefetchSize tune consumer back-pressure
+	// via Channel.Qos, applied on every (re)connect.
+	metadataPrefetchCount = "prefetchCount"
+	metadataPrefetchSize  = "prefetchSize"
+
+	// metadataMaxPriority declares the queue with "x-max-priority" so that
+	// metadataConsumerPriority and a per-publish priority take effect.
+	metadataMaxPriority = "maxPriority"
+	// metadataConsumerPriority is sent as "x-priority" in the Consume args.
+	metadataConsumerPriority = "consumerPriority"
+	// metadataPriority is a per-publish message priority, honored only on a
+	// queue declared with a maxPriority.
+	metadataPriority = "priority"
+
+	argDeadLetterExchange   = "x-dead-letter-exchange"
+	argDeadLetterRoutingKey = "x-dead-letter-routing-key"
+	argMessageTTL           = "x-message-ttl"
+	argMaxPriority          = "x-max-priority"
+	argConsumerPriority     = "x-priority"
+	headerDeath             = "x-death"
+	headerDeathCount        = "count"
+
+	defaultExchangeKind             = "fanout"
+	defaultDelayedExchangeType      = "topic"
+	defaultMaxDeliveryAttempts      = 5
+	defaultReconnectWaitSeconds     = 5
+	defaultPublishConfirmTimeout    = 5 * time.Second
+	defaultPublishReconnectAttempts = 2
+)
+
+// rabbitMQConnectionBroker is the subset of a driver's connection used by
+// this component; it is an alias of pubsub/amqp's exported type so that
+// package's transports satisfy it directly, and tests can still substitute
+// an in-memory fake.
+type rabbitMQConnectionBroker = rabbitmqamqp.ConnectionBroker
+
+// rabbitMQChannelBroker is the subset of a driver's channel used by this
+// component; it is an alias of pubsub/amqp's exported type so that
+// package's transports satisfy it directly, and tests can still substitute
+// an in-memory fake.
+type rabbitMQChannelBroker = rabbitmqamqp.ChannelBroker
+
+type rabbitMQMetadata struct {
+	host                  string
+	driver                string
+	consumerID            string
+	durable               bool
+	deleteWhenUnused      bool
+	autoAck               bool
+	requeueInFailure      bool
+	reconnectWait         time.Duration
+	exchangeKind          string
+	exchangeKindSet       bool
+	publisherConfirms     bool
+	publishConfirmTimeout time.Duration
+	useDelayedExchange    bool
+
+	enableDeadLetter     bool
+	deadLetterExchange   string
+	deadLetterRoutingKey string
+	maxDeliveryAttempts  int
+	retryTTL             time.Duration
+
+	prefetchCount int
+	prefetchSize  int
+
+	maxPriority         int
+	maxPrioritySet      bool
+	consumerPriority    int
+	consumerPrioritySet bool
+
+	concurrency pubsub.ConcurrencyMode
+}
+
+// rabbitMQ is a pubsub.PubSub implementation backed by RabbitMQ.
+type rabbitMQ struct {
+	connection rabbitMQConnectionBroker
+	channel    rabbitMQChannelBroker
+	confirms   chan amqp.Confirmation
+
+	channelMutex      sync.RWMutex
+	connectionCount   int
+	declaredExchanges map[string]bool
+	stopped           bool
+
+	// publishMutex serializes publishes while publisherConfirms is on, since
+	// confirms is registered once per channel (see setupChannel) and every
+	// confirmation the broker sends is delivered on that one channel: only one
+	// publish may be waiting on it at a time, or a publish could read back the
+	// confirmation meant for a different, concurrent one.
+	publishMutex sync.Mutex
+
+	metadata rabbitMQMetadata
+	logger   logger.Logger
+
+	connectionDial func(host string) (rabbitMQConnectionBroker, rabbitMQChannelBroker, error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRabbitMQ returns a new RabbitMQ pubsub instance. The transport used to
+// reach the broker is resolved from metadata at Init time, defaulting to the
+// maintained amqp091 driver; connectionDial is left nil here so Init can
+// tell a test-injected fake apart from "use the registry".
+func NewRabbitMQ(logger logger.Logger) pubsub.PubSub {
+	return &rabbitMQ{
+		declaredExchanges: make(map[string]bool),
+		logger:            logger,
+	}
+}
+
+func parseRabbitMQMetadata(metadata pubsub.Metadata) (rabbitMQMetadata, error) {
+	m := rabbitMQMetadata{
+		driver:                rabbitmqamqp.DriverAMQP091,
+		durable:               true,
+		requeueInFailure:      true,
+		exchangeKind:          defaultExchangeKind,
+		reconnectWait:         defaultReconnectWaitSeconds * time.Second,
+		publishConfirmTimeout: defaultPublishConfirmTimeout,
+		concurrency:           pubsub.Parallel,
+	}
+
+	if val, ok := metadata.Properties[metadataHostKey]; ok && val != "" {
+		m.host = val
+	} else {
+		return m, errors.New("rabbitmq pub/sub error: missing RabbitMQ host")
+	}
+
+	if val, ok := metadata.Properties[metadataConsumerIDKey]; ok && val != "" {
+		m.consumerID = val
+	}
+
+	if val, ok := metadata.Properties[metadataProtocolVersion]; ok && val == protocolVersion10 {
+		m.driver = rabbitmqamqp.DriverQpidProton
+	}
+
+	// metadataDriver, if set, overrides whatever protocolVersion selected.
+	if val, ok := metadata.Properties[metadataDriver]; ok && val != "" {
+		m.driver = val
+	}
+
+	if val, ok := metadata.Properties[metadataDurable]; ok && val != "" {
+		m.durable, _ = strconv.ParseBool(val)
+	}
+
+	if val, ok := metadata.Properties[metadataDeleteWhenUnused]; ok && val != "" {
+		m.deleteWhenUnused, _ = strconv.ParseBool(val)
+	}
+
+	if val, ok := metadata.Properties[metadataAutoAck]; ok && val != "" {
+		m.autoAck, _ = strconv.ParseBool(val)
+	}
+
+	if val, ok := metadata.Properties[metadataRequeueInFailure]; ok && val != "" {
+		m.requeueInFailure, _ = strconv.ParseBool(val)
+	}
+
+	if val, ok := metadata.Properties[metadataExchangeKind]; ok && val != "" {
+		m.exchangeKind = val
+		m.exchangeKindSet = true
+	}
+
+	if val, ok := metadata.Properties[metadataUseDelayedExchange]; ok && val != "" {
+		m.useDelayedExchange, _ = strconv.ParseBool(val)
+	}
+
+	// The delayed-message-exchange plugin routes on the "underlying" type it
+	// wraps; default that to topic rather than fanout, since delayed messages
+	// are usually meant to land in a single delayed recipient's queue.
+	if m.useDelayedExchange && !m.exchangeKindSet {
+		m.exchangeKind = defaultDelayedExchangeType
+	}
+
+	if val, ok := metadata.Properties[metadataReconnectWaitSeconds]; ok && val != "" {
+		seconds, err := strconv.Atoi(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataReconnectWaitSeconds, err)
+		}
+		m.reconnectWait = time.Duration(seconds) * time.Second
+	}
+
+	if val, ok := metadata.Properties[metadataPublisherConfirms]; ok && val != "" {
+		m.publisherConfirms, _ = strconv.ParseBool(val)
+	}
+
+	if val, ok := metadata.Properties[metadataPublishConfirmTimeout]; ok && val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataPublishConfirmTimeout, err)
+		}
+		m.publishConfirmTimeout = d
+	}
+
+	if val, ok := metadata.Properties[pubsub.ConcurrencyKey]; ok && val != "" {
+		m.concurrency = pubsub.ConcurrencyMode(val)
+	}
+
+	if val, ok := metadata.Properties[metadataEnableDeadLetter]; ok && val != "" {
+		m.enableDeadLetter, _ = strconv.ParseBool(val)
+	}
+
+	if m.enableDeadLetter {
+		m.maxDeliveryAttempts = defaultMaxDeliveryAttempts
+
+		if val, ok := metadata.Properties[metadataDeadLetterExchange]; ok && val != "" {
+			m.deadLetterExchange = val
+		}
+
+		if val, ok := metadata.Properties[metadataDeadLetterRoutingKey]; ok && val != "" {
+			m.deadLetterRoutingKey = val
+		}
+
+		if val, ok := metadata.Properties[metadataMaxDeliveryAttempts]; ok && val != "" {
+			attempts, err := strconv.Atoi(val)
+			if err != nil {
+				return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataMaxDeliveryAttempts, err)
+			}
+			m.maxDeliveryAttempts = attempts
+		}
+
+		if val, ok := metadata.Properties[metadataRetryTTL]; ok && val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataRetryTTL, err)
+			}
+			m.retryTTL = d
+		}
+	}
+
+	if val, ok := metadata.Properties[metadataPrefetchCount]; ok && val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataPrefetchCount, err)
+		}
+		m.prefetchCount = n
+	}
+
+	if val, ok := metadata.Properties[metadataPrefetchSize]; ok && val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataPrefetchSize, err)
+		}
+		m.prefetchSize = n
+	}
+
+	if val, ok := metadata.Properties[metadataMaxPriority]; ok && val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataMaxPriority, err)
+		}
+		m.maxPriority = n
+		m.maxPrioritySet = true
+	}
+
+	if val, ok := metadata.Properties[metadataConsumerPriority]; ok && val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return m, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataConsumerPriority, err)
+		}
+		m.consumerPriority = n
+		m.consumerPrioritySet = true
+	}
+
+	return m, nil
+}
+
+// deliveryDelayMilliseconds computes the rabbitmq_delayed_message_exchange
+// "x-delay" value, in milliseconds, from a per-publish deliverAfter duration
+// or deliverAt RFC3339 timestamp. It returns 0 if neither is set.
+func deliveryDelayMilliseconds(metadata map[string]string) (int64, error) {
+	if val, ok := metadata[metadataDeliverAfter]; ok && val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return 0, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataDeliverAfter, err)
+		}
+
+		return d.Milliseconds(), nil
+	}
+
+	if val, ok := metadata[metadataDeliverAt]; ok && val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return 0, fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataDeliverAt, err)
+		}
+
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+
+		return delay.Milliseconds(), nil
+	}
+
+	return 0, nil
+}
+
+func (r *rabbitMQ) Init(metadata pubsub.Metadata) error {
+	meta, err := parseRabbitMQMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	r.metadata = meta
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	if r.connectionDial == nil {
+		transport, err := rabbitmqamqp.Lookup(meta.driver)
+		if err != nil {
+			return fmt.Errorf("rabbitmq pub/sub error: %w", err)
+		}
+		r.connectionDial = transport.Dial
+	}
+
+	return r.connect()
+}
+
+// connect dials the broker and, on success, applies Qos, puts the new
+// channel into publisher-confirms mode, and arms the NotifyReturn listener
+// when configured.
+func (r *rabbitMQ) connect() error {
+	conn, channel, err := r.connectionDial(r.metadata.host)
+	if err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error connecting to RabbitMQ: %w", err)
+	}
+
+	r.connection = conn
+	r.channel = channel
+	r.connectionCount++
+	r.declaredExchanges = make(map[string]bool)
+
+	return r.setupChannel(channel)
+}
+
+// setupChannel applies per-connection settings to a freshly dialed or
+// redialed channel: consumer Qos (prefetchCount/prefetchSize), the
+// NotifyReturn listener, and, when configured, publisher confirms. It runs
+// identically on the initial connect and on every reconnect, since none of
+// these settings survive a redial.
+func (r *rabbitMQ) setupChannel(channel rabbitMQChannelBroker) error {
+	if err := channel.Qos(r.metadata.prefetchCount, r.metadata.prefetchSize, false); err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error setting Qos: %w", err)
+	}
+
+	returns := channel.NotifyReturn(make(chan amqp.Return, 1))
+	go r.watchReturns(returns)
+
+	if !r.metadata.publisherConfirms {
+		return nil
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error putting channel into confirm mode: %w", err)
+	}
+
+	// NotifyPublish is registered exactly once here, not per publish: the
+	// underlying drivers append every registered listener to an
+	// ever-growing, never-trimmed list and broadcast every confirmation to
+	// all of them, so calling this per publish leaks a listener (and, on
+	// streadway, a forwarding goroutine) for the life of the connection.
+	// publishSync's publishMutex then ensures only one publish is ever
+	// waiting on this shared channel at a time.
+	r.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	return nil
+}
+
+// watchReturns logs messages the broker could not route to any queue, which
+// would otherwise be silently dropped by a mandatory publish.
+func (r *rabbitMQ) watchReturns(returns chan amqp.Return) {
+	for ret := range returns {
+		r.logger.Errorf("rabbitmq pub/sub error: message returned by broker, exchange=%s routing key=%s reply=%d/%s", ret.Exchange, ret.RoutingKey, ret.ReplyCode, ret.ReplyText)
+	}
+}
+
+func (r *rabbitMQ) Features() []pubsub.Feature {
+	return nil
+}
+
+// ensureExchangeDeclared declares exchange on channel the first time it is
+// seen and remembers it in declaredExchanges so concurrent callers don't
+// redeclare it on every publish/subscribe. The whole check-and-set is done
+// under channelMutex's exclusive lock, since declaredExchanges is a plain
+// map and Publish/Subscribe may call this concurrently for different topics.
+func (r *rabbitMQ) ensureExchangeDeclared(channel rabbitMQChannelBroker, exchange string) error {
+	r.channelMutex.Lock()
+	defer r.channelMutex.Unlock()
+
+	if r.declaredExchanges[exchange] {
+		return nil
+	}
+
+	kind := r.metadata.exchangeKind
+	var args amqp.Table
+	if r.metadata.useDelayedExchange {
+		args = amqp.Table{delayedExchangeTypeArg: kind}
+		kind = delayedExchangeKind
+	}
+
+	if err := channel.ExchangeDeclare(exchange, kind, r.metadata.durable, r.metadata.deleteWhenUnused, false, false, args); err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error declaring exchange %s: %w", exchange, err)
+	}
+
+	r.declaredExchanges[exchange] = true
+
+	return nil
+}
+
+func (r *rabbitMQ) Publish(req *pubsub.PublishRequest) error {
+	r.channelMutex.RLock()
+	stopped := r.stopped
+	r.channelMutex.RUnlock()
+	if stopped {
+		return errors.New("rabbitmq pub/sub instance stopped")
+	}
+
+	err := r.publishSync(req)
+	if err == nil {
+		return nil
+	}
+
+	// A Nack or confirm timeout is the broker's answer, not a connection
+	// failure: reconnecting and republishing would risk delivering the
+	// message a second time while masking the original Nack, so it is
+	// surfaced immediately instead of retried.
+	var notConfirmed *publishNotConfirmedError
+	if errors.As(err, &notConfirmed) {
+		return err
+	}
+
+	r.channelMutex.RLock()
+	stopped = r.stopped
+	connectionCount := r.connectionCount
+	r.channelMutex.RUnlock()
+	if stopped {
+		return err
+	}
+
+	for attempt := 0; attempt < defaultPublishReconnectAttempts; attempt++ {
+		if rerr := r.reconnect(connectionCount); rerr != nil {
+			return rerr
+		}
+
+		r.channelMutex.RLock()
+		connectionCount = r.connectionCount
+		r.channelMutex.RUnlock()
+
+		err = r.publishSync(req)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (r *rabbitMQ) publishSync(req *pubsub.PublishRequest) error {
+	r.channelMutex.RLock()
+	channel := r.channel
+	confirms := r.confirms
+	r.channelMutex.RUnlock()
+
+	if err := r.ensureExchangeDeclared(channel, req.Topic); err != nil {
+		return err
+	}
+
+	p := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        req.Data,
+	}
+
+	if r.metadata.useDelayedExchange {
+		delayMs, err := deliveryDelayMilliseconds(req.Metadata)
+		if err != nil {
+			return err
+		}
+		if delayMs > 0 {
+			p.Headers = amqp.Table{delayHeader: delayMs}
+		}
+	}
+
+	if val, ok := req.Metadata[metadataPriority]; ok && val != "" {
+		priority, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("rabbitmq pub/sub error: invalid %s: %w", metadataPriority, err)
+		}
+		p.Priority = uint8(priority)
+	}
+
+	if r.metadata.publisherConfirms {
+		// Only one publish may be in flight on the shared confirms channel at
+		// a time (see setupChannel); held until this publish's own
+		// confirmation arrives.
+		r.publishMutex.Lock()
+		defer r.publishMutex.Unlock()
+	}
+
+	// mandatory is always set so the broker returns, rather than silently
+	// drops, a message it cannot route to any queue.
+	if err := channel.Publish(req.Topic, "", true, false, p); err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error publishing to %s: %w", req.Topic, err)
+	}
+
+	if !r.metadata.publisherConfirms {
+		return nil
+	}
+
+	select {
+	case confirmation, ok := <-confirms:
+		if !ok {
+			return &publishNotConfirmedError{topic: req.Topic, reason: "confirmation channel closed while publishing"}
+		}
+		if !confirmation.Ack {
+			return &publishNotConfirmedError{topic: req.Topic, reason: "broker nacked publish"}
+		}
+
+		return nil
+	case <-time.After(r.metadata.publishConfirmTimeout):
+		return &publishNotConfirmedError{topic: req.Topic, reason: "timed out waiting for publisher confirm"}
+	}
+}
+
+// publishNotConfirmedError reports that publisherConfirms was on and the
+// broker explicitly nacked the publish, closed the confirmation channel, or
+// never confirmed it before publishConfirmTimeout elapsed. It is distinct
+// from a transport error so Publish's retry loop can tell them apart: none
+// of these are fixed by reconnecting, and retrying would risk delivering the
+// message a second time while discarding the original answer.
+type publishNotConfirmedError struct {
+	topic  string
+	reason string
+}
+
+func (e *publishNotConfirmedError) Error() string {
+	return fmt.Sprintf("rabbitmq pub/sub error: %s on %s", e.reason, e.topic)
+}
+
+// reconnect redials the broker, but only if connectionCount still matches the
+// generation the caller observed failing; this coalesces concurrent
+// reconnect attempts triggered by the same underlying connection loss.
+func (r *rabbitMQ) reconnect(connectionCount int) error {
+	r.channelMutex.Lock()
+	defer r.channelMutex.Unlock()
+
+	if connectionCount != r.connectionCount {
+		// Another goroutine already reconnected.
+		return nil
+	}
+
+	if r.channel != nil {
+		r.channel.Close()
+	}
+	if r.connection != nil {
+		r.connection.Close()
+	}
+
+	conn, channel, err := r.connectionDial(r.metadata.host)
+	if err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error reconnecting to RabbitMQ: %w", err)
+	}
+
+	r.connection = conn
+	r.channel = channel
+	r.connectionCount++
+	r.declaredExchanges = make(map[string]bool)
+
+	return r.setupChannel(channel)
+}
+
+func (r *rabbitMQ) Subscribe(req pubsub.SubscribeRequest, handler pubsub.Handler) error {
+	if r.metadata.consumerID == "" {
+		return errors.New("rabbitmq pub/sub error: consumerID is required for subscriptions")
+	}
+
+	r.channelMutex.RLock()
+	channel := r.channel
+	r.channelMutex.RUnlock()
+
+	if err := r.ensureExchangeDeclared(channel, req.Topic); err != nil {
+		return err
+	}
+
+	queueName := fmt.Sprintf("%s-%s", req.Topic, r.metadata.consumerID)
+
+	// bindingKey is the routing key the primary queue is bound with below;
+	// declareDeadLetterTopology needs the same value so a message it
+	// dead-letters back from the retry queue still matches that binding on
+	// a non-fanout exchange.
+	const bindingKey = ""
+
+	queueArgs, err := r.declareDeadLetterTopology(channel, req.Topic, queueName, bindingKey)
+	if err != nil {
+		return err
+	}
+
+	if r.metadata.maxPrioritySet {
+		if queueArgs == nil {
+			queueArgs = amqp.Table{}
+		}
+		queueArgs[argMaxPriority] = r.metadata.maxPriority
+	}
+
+	queue, err := channel.QueueDeclare(queueName, r.metadata.durable, r.metadata.deleteWhenUnused, false, false, queueArgs)
+	if err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error declaring queue %s: %w", req.Topic, err)
+	}
+
+	if err = channel.QueueBind(queue.Name, bindingKey, req.Topic, false, nil); err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error binding queue %s: %w", req.Topic, err)
+	}
+
+	r.channelMutex.RLock()
+	connectionCount := r.connectionCount
+	r.channelMutex.RUnlock()
+
+	// Consume is called synchronously so Subscribe only returns once the
+	// broker has actually registered the consumer (with whatever
+	// consumeArgs it was asked for); only the receive loop itself runs in
+	// the background.
+	msgs, err := channel.Consume(queue.Name, "", r.metadata.autoAck, false, false, false, r.consumeArgs())
+	if err != nil {
+		return fmt.Errorf("rabbitmq pub/sub error: error consuming queue %s: %w", queue.Name, err)
+	}
+
+	go r.runConsumer(msgs, connectionCount, queue.Name, req.Topic, handler)
+
+	return nil
+}
+
+// declareDeadLetterTopology declares the dead-letter exchange/queue and a
+// per-queue retry queue that dead-letters expired messages back to the
+// primary topic exchange, so every in-budget failure passes through a real
+// dead-letter hop (and so accumulates x-death) on its way back, rather than
+// only the ones past maxDeliveryAttempts. The retry queue's
+// x-dead-letter-routing-key is set to bindingKey, the same routing key the
+// primary queue is bound with: without it, a dead-lettered message carries
+// the retry queue's own name as its routing key (reusing its original
+// routing key, per AMQP's default), which a fanout exchange ignores but a
+// topic/direct exchange would fail to route back to the primary queue,
+// silently dropping the retried message. It returns the arguments the
+// primary queue must be declared with, or nil if dead-lettering is disabled.
+func (r *rabbitMQ) declareDeadLetterTopology(channel rabbitMQChannelBroker, topic string, queueName string, bindingKey string) (amqp.Table, error) {
+	if !r.metadata.enableDeadLetter {
+		return nil, nil
+	}
+
+	dlx := r.metadata.deadLetterExchange
+	if dlx == "" {
+		dlx = topic + ".dlx"
+	}
+
+	if err := channel.ExchangeDeclare(dlx, "fanout", r.metadata.durable, r.metadata.deleteWhenUnused, false, false, nil); err != nil {
+		return nil, fmt.Errorf("rabbitmq pub/sub error: error declaring dead-letter exchange %s: %w", dlx, err)
+	}
+
+	dlq := deadLetterQueueName(queueName)
+	if _, err := channel.QueueDeclare(dlq, r.metadata.durable, r.metadata.deleteWhenUnused, false, false, nil); err != nil {
+		return nil, fmt.Errorf("rabbitmq pub/sub error: error declaring dead-letter queue %s: %w", dlq, err)
+	}
+
+	if err := channel.QueueBind(dlq, r.metadata.deadLetterRoutingKey, dlx, false, nil); err != nil {
+		return nil, fmt.Errorf("rabbitmq pub/sub error: error binding dead-letter queue %s: %w", dlq, err)
+	}
+
+	args := amqp.Table{argDeadLetterExchange: dlx}
+	if r.metadata.deadLetterRoutingKey != "" {
+		args[argDeadLetterRoutingKey] = r.metadata.deadLetterRoutingKey
+	}
+
+	retryQueue := retryQueueName(queueName)
+	retryArgs := amqp.Table{
+		argMessageTTL:           r.metadata.retryTTL.Milliseconds(),
+		argDeadLetterExchange:   topic,
+		argDeadLetterRoutingKey: bindingKey,
+	}
+	if _, err := channel.QueueDeclare(retryQueue, r.metadata.durable, r.metadata.deleteWhenUnused, false, false, retryArgs); err != nil {
+		return nil, fmt.Errorf("rabbitmq pub/sub error: error declaring retry queue %s: %w", retryQueue, err)
+	}
+
+	return args, nil
+}
+
+func deadLetterQueueName(queueName string) string {
+	return queueName + ".dlq"
+}
+
+func retryQueueName(queueName string) string {
+	return queueName + ".retry"
+}
+
+// consumeArgs builds the Consume args table, setting "x-priority" when a
+// consumerPriority is configured. It returns nil otherwise, matching the
+// amqp driver's convention of nil meaning "no args".
+func (r *rabbitMQ) consumeArgs() amqp.Table {
+	if !r.metadata.consumerPrioritySet {
+		return nil
+	}
+
+	return amqp.Table{argConsumerPriority: r.metadata.consumerPriority}
+}
+
+// deliveryAttempts returns how many times the broker has previously
+// dead-lettered this delivery, derived from the standard x-death header.
+func deliveryAttempts(headers amqp.Table) int {
+	raw, ok := headers[headerDeath]
+	if !ok {
+		return 0
+	}
+
+	deaths, ok := raw.([]interface{})
+	if !ok || len(deaths) == 0 {
+		return 0
+	}
+
+	first, ok := deaths[0].(amqp.Table)
+	if !ok {
+		return 0
+	}
+
+	switch v := first[headerDeathCount].(type) {
+	case int64:
+		return int(v)
+	case int32:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// runConsumer owns the single delivery channel for a subscription for its
+// entire lifetime: it is the only goroutine that ever reads from it, even
+// across reconnects, so a handler failure can never race a fresh consumer
+// against a stale one for the same deliveries. Subscribe has already called
+// Consume synchronously to obtain msgs/connectionCount before spawning this,
+// so the consumer is guaranteed registered with the broker by the time
+// Subscribe returns.
+func (r *rabbitMQ) runConsumer(msgs <-chan amqp.Delivery, connectionCount int, queueName string, topic string, handler pubsub.Handler) {
+	// Buffered by 1: concurrent handler failures only need to trigger one
+	// reconnect; further requests for the same generation are dropped.
+	reconnectRequests := make(chan int, 1)
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case connCount := <-reconnectRequests:
+			if newMsgs, newCount, ok := r.resume(connCount, queueName); ok {
+				msgs, connectionCount = newMsgs, newCount
+			}
+		case d, ok := <-msgs:
+			if !ok {
+				return
+			}
+
+			if r.metadata.concurrency == pubsub.Single {
+				if !r.handleMessage(d, topic, queueName, handler) {
+					if newMsgs, newCount, ok := r.resume(connectionCount, queueName); ok {
+						msgs, connectionCount = newMsgs, newCount
+					}
+				}
+			} else {
+				connCount := connectionCount
+				go func(delivery amqp.Delivery) {
+					if !r.handleMessage(delivery, topic, queueName, handler) {
+						select {
+						case reconnectRequests <- connCount:
+						default:
+						}
+					}
+				}(d)
+			}
+		}
+	}
+}
+
+// handleMessage invokes the user handler and acks/nacks accordingly,
+// returning false if the message failed so the caller can trigger a
+// reconnect. With dead-lettering enabled, a failure that brings the delivery
+// count to maxDeliveryAttempts is quarantined instead of requeued; one within budget always goes through
+// the retry queue (never a direct requeue), since that is what makes
+// deliveryAttempts/x-death actually accumulate towards the limit. Without
+// dead-lettering it is requeued directly, as before.
+func (r *rabbitMQ) handleMessage(d amqp.Delivery, topic string, queueName string, handler pubsub.Handler) bool {
+	msg := &pubsub.NewMessage{
+		Data:  d.Body,
+		Topic: topic,
+	}
+
+	err := handler(r.ctx, msg)
+	if err == nil {
+		if !r.metadata.autoAck {
+			d.Ack(false)
+		}
+
+		return true
+	}
+
+	r.logger.Errorf("rabbitmq pub/sub error: error handling message from %s: %v", topic, err)
+
+	if r.metadata.autoAck {
+		return false
+	}
+
+	if r.metadata.enableDeadLetter && deliveryAttempts(d.Headers)+1 >= r.metadata.maxDeliveryAttempts {
+		r.logger.Warnf("rabbitmq pub/sub: quarantining poison message from %s to %s after %d delivery attempts", topic, deadLetterQueueName(queueName), deliveryAttempts(d.Headers)+1)
+		d.Nack(false, false)
+
+		return false
+	}
+
+	if r.metadata.enableDeadLetter {
+		if err := r.scheduleRetry(queueName, d); err != nil {
+			r.logger.Errorf("rabbitmq pub/sub error: error scheduling retry for %s: %v", topic, err)
+			d.Nack(false, r.metadata.requeueInFailure)
+		} else {
+			d.Ack(false)
+		}
+
+		return false
+	}
+
+	d.Nack(false, r.metadata.requeueInFailure)
+
+	return false
+}
+
+// scheduleRetry republishes a failed delivery onto its queue's retry queue,
+// which holds it for retryTTL before dead-lettering it back to the topic
+// exchange for redelivery.
+func (r *rabbitMQ) scheduleRetry(queueName string, d amqp.Delivery) error {
+	r.channelMutex.RLock()
+	channel := r.channel
+	r.channelMutex.RUnlock()
+
+	return channel.Publish("", retryQueueName(queueName), false, false, amqp.Publishing{
+		ContentType: d.ContentType,
+		Body:        d.Body,
+		Headers:     d.Headers,
+	})
+}
+
+// resume waits out the configured backoff, reconnects (a no-op if another
+// caller already recovered the same generation) and re-establishes the
+// consumer on the resulting channel.
+func (r *rabbitMQ) resume(connectionCount int, queueName string) (<-chan amqp.Delivery, int, bool) {
+	time.Sleep(r.metadata.reconnectWait)
+
+	if err := r.reconnect(connectionCount); err != nil {
+		r.logger.Errorf("rabbitmq pub/sub error: error reconnecting: %v", err)
+
+		return nil, 0, false
+	}
+
+	r.channelMutex.RLock()
+	channel := r.channel
+	newCount := r.connectionCount
+	r.channelMutex.RUnlock()
+
+	msgs, err := channel.Consume(queueName, "", r.metadata.autoAck, false, false, false, r.consumeArgs())
+	if err != nil {
+		r.logger.Errorf("rabbitmq pub/sub error: error resuming consumer on %s: %v", queueName, err)
+
+		return nil, 0, false
+	}
+
+	return msgs, newCount, true
+}
+
+func (r *rabbitMQ) Close() error {
+	r.channelMutex.Lock()
+	defer r.channelMutex.Unlock()
+
+	r.stopped = true
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	if r.channel != nil {
+		r.channel.Close()
+	}
+	if r.connection != nil {
+		r.connection.Close()
+	}
+
+	return nil
+}
@@ -16,13 +16,16 @@ package rabbitmq
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/streadway/amqp"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/dapr/components-contrib/pubsub"
+	rabbitmqamqp "github.com/dapr/components-contrib/pubsub/amqp"
 	"github.com/dapr/kit/logger"
 )
 
@@ -31,33 +34,17 @@ const (
 	testMetadataConsumerIDValue = "consumer"
 )
 
-type FakeAcknowledger struct {
+func newBroker() *rabbitmqamqp.InMemoryBroker {
+	return rabbitmqamqp.NewInMemoryBroker()
 }
 
-func (a FakeAcknowledger) Ack(tag uint64, multiple bool) error {
-	return nil
-}
-
-func (a FakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
-	return nil
-}
-func (a FakeAcknowledger) Reject(tag uint64, requeue bool) error {
-	return nil
-}
-
-func newBroker() *rabbitMQInMemoryBroker {
-	return &rabbitMQInMemoryBroker{
-		buffer: make(chan amqp.Delivery, 2),
-	}
-}
-
-func newRabbitMQTest(broker *rabbitMQInMemoryBroker) pubsub.PubSub {
+func newRabbitMQTest(broker *rabbitmqamqp.InMemoryBroker) pubsub.PubSub {
 	return &rabbitMQ{
 		declaredExchanges: make(map[string]bool),
 		stopped:           false,
 		logger:            logger.NewLogger("test"),
 		connectionDial: func(host string) (rabbitMQConnectionBroker, rabbitMQChannelBroker, error) {
-			broker.connectCount++
+			broker.ConnectCount++
 
 			return broker, broker, nil
 		},
@@ -143,8 +130,8 @@ func TestPublishAndSubscribe(t *testing.T) {
 	}
 	err := pubsubRabbitMQ.Init(metadata)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 0, broker.closeCount)
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 0, broker.CloseCount)
 
 	topic := "mytopic"
 
@@ -175,6 +162,50 @@ func TestPublishAndSubscribe(t *testing.T) {
 	assert.Equal(t, "foo bar", lastMessage)
 }
 
+// TestPublishConcurrentTopicsDoesNotRace guards against a regression where
+// ensureExchangeDeclared read and wrote declaredExchanges without holding
+// channelMutex: concurrent Publish calls to previously-undeclared topics
+// raced on the map write and crashed with "fatal error: concurrent map
+// writes" (the runtime only detects this intermittently; go test -race
+// reports it reliably).
+func TestPublishConcurrentTopicsDoesNotRace(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:       testMetadataHostValue,
+			metadataConsumerIDKey: testMetadataConsumerIDValue,
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+
+	const topicCount = 20
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-broker.Buffer:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer close(stop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < topicCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			topic := fmt.Sprintf("concurrent-topic-%d", i)
+			assert.Nil(t, pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte("hello")}))
+		}(i)
+	}
+	wg.Wait()
+}
+
 func TestPublishReconnect(t *testing.T) {
 	broker := newBroker()
 	pubsubRabbitMQ := newRabbitMQTest(broker)
@@ -186,8 +217,8 @@ func TestPublishReconnect(t *testing.T) {
 	}
 	err := pubsubRabbitMQ.Init(metadata)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 0, broker.closeCount)
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 0, broker.CloseCount)
 
 	topic := "othertopic"
 
@@ -211,13 +242,13 @@ func TestPublishReconnect(t *testing.T) {
 	assert.Equal(t, 1, messageCount)
 	assert.Equal(t, "hello world", lastMessage)
 
-	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte(errorChannelConnection)})
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte(rabbitmqamqp.ErrConnectionClosed)})
 	assert.NotNil(t, err)
 	assert.Equal(t, 1, messageCount)
 	assert.Equal(t, "hello world", lastMessage)
 	// Check that reconnection happened
-	assert.Equal(t, 3, broker.connectCount) // three counts - one initial connection plus 2 reconnect attempts
-	assert.Equal(t, 4, broker.closeCount)   // four counts - one for connection, one for channel , times 2 reconnect attempts
+	assert.Equal(t, 3, broker.ConnectCount) // three counts - one initial connection plus 2 reconnect attempts
+	assert.Equal(t, 4, broker.CloseCount)   // four counts - one for connection, one for channel , times 2 reconnect attempts
 
 	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte("foo bar")})
 	assert.Nil(t, err)
@@ -237,8 +268,8 @@ func TestPublishReconnectAfterClose(t *testing.T) {
 	}
 	err := pubsubRabbitMQ.Init(metadata)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 0, broker.closeCount)
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 0, broker.CloseCount)
 
 	topic := "mytopic2"
 
@@ -265,15 +296,15 @@ func TestPublishReconnectAfterClose(t *testing.T) {
 	// Close PubSub
 	err = pubsubRabbitMQ.Close()
 	assert.Nil(t, err)
-	assert.Equal(t, 2, broker.closeCount) // two counts - one for connection, one for channel
+	assert.Equal(t, 2, broker.CloseCount) // two counts - one for connection, one for channel
 
-	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte(errorChannelConnection)})
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: topic, Data: []byte(rabbitmqamqp.ErrConnectionClosed)})
 	assert.NotNil(t, err)
 	assert.Equal(t, 1, messageCount)
 	assert.Equal(t, "hello world", lastMessage)
 	// Check that reconnection did not happened
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 2, broker.closeCount) // two counts - one for connection, one for channel
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 2, broker.CloseCount) // two counts - one for connection, one for channel
 }
 
 func TestSubscribeReconnect(t *testing.T) {
@@ -294,8 +325,8 @@ func testSingleModeSubscribeReconnect(t *testing.T) {
 	}
 	err := pubsubRabbitMQ.Init(metadata)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 0, broker.closeCount)
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 0, broker.CloseCount)
 
 	topic := "thetopic"
 
@@ -307,7 +338,7 @@ func testSingleModeSubscribeReconnect(t *testing.T) {
 		lastMessage = string(msg.Data)
 		processed <- true
 
-		return errors.New(errorChannelConnection)
+		return errors.New(rabbitmqamqp.ErrConnectionClosed)
 	}
 
 	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: topic}, handler)
@@ -329,8 +360,8 @@ func testSingleModeSubscribeReconnect(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// Check that reconnection happened
-	assert.Equal(t, 3, broker.connectCount) // initial connect + 2 reconnects
-	assert.Equal(t, 4, broker.closeCount)   // two counts for each connection closure - one for connection, one for channel
+	assert.Equal(t, 3, broker.ConnectCount) // initial connect + 2 reconnects
+	assert.Equal(t, 4, broker.CloseCount)   // two counts for each connection closure - one for connection, one for channel
 }
 
 func testParallelModeSubscribeReconnect(t *testing.T) {
@@ -346,8 +377,8 @@ func testParallelModeSubscribeReconnect(t *testing.T) {
 	}
 	err := pubsubRabbitMQ.Init(metadata)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, broker.connectCount)
-	assert.Equal(t, 0, broker.closeCount)
+	assert.Equal(t, 1, broker.ConnectCount)
+	assert.Equal(t, 0, broker.CloseCount)
 
 	topic := "thetopic"
 
@@ -359,7 +390,7 @@ func testParallelModeSubscribeReconnect(t *testing.T) {
 		lastMessage = string(msg.Data)
 		processed <- true
 
-		return errors.New(errorChannelConnection)
+		return errors.New(rabbitmqamqp.ErrConnectionClosed)
 	}
 
 	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: topic}, handler)
@@ -384,64 +415,339 @@ func testParallelModeSubscribeReconnect(t *testing.T) {
 	time.Sleep(time.Second)
 
 	// Check that reconnection happened
-	assert.Equal(t, 3, broker.connectCount) // initial connect + 2 reconnects
-	assert.Equal(t, 4, broker.closeCount)   // two counts for each connection closure - one for connection, one for channel
+	assert.Equal(t, 3, broker.ConnectCount) // initial connect + 2 reconnects
+	assert.Equal(t, 4, broker.CloseCount)   // two counts for each connection closure - one for connection, one for channel
 }
 
-func createAMQPMessage(body []byte) amqp.Delivery {
-	return amqp.Delivery{
-		Body:         body,
-		Acknowledger: FakeAcknowledger{},
+func TestPublishWithPublisherConfirmsNack(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:           testMetadataHostValue,
+			metadataConsumerIDKey:     testMetadataConsumerIDValue,
+			metadataPublisherConfirms: "true",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+	assert.True(t, broker.ConfirmMode)
+
+	broker.NextConfirmNack = true
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: "mytopic", Data: []byte("hello world")})
+	assert.NotNil(t, err)
+	// A Nack is the broker's answer, not a connection failure: Publish must
+	// surface it directly instead of reconnecting and republishing, which
+	// would risk delivering the message a second time while silently
+	// discarding the original Nack.
+	assert.Equal(t, 1, broker.ConnectCount, "a nacked publish must not trigger a reconnect/retry")
+}
+
+// TestPublishRegistersNotifyPublishOncePerChannel guards against a
+// regression where NotifyPublish was re-registered on every Publish call:
+// the underlying drivers append every registered listener to a list that is
+// only cleared on channel close, so doing that on every publish leaks a
+// listener (and, on some drivers, a goroutine) for the life of the
+// connection.
+func TestPublishRegistersNotifyPublishOncePerChannel(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:           testMetadataHostValue,
+			metadataConsumerIDKey:     testMetadataConsumerIDValue,
+			metadataPublisherConfirms: "true",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, broker.NotifyPublishCallCount)
+
+	for i := 0; i < 5; i++ {
+		err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: "mytopic", Data: []byte("hello world")})
+		assert.Nil(t, err)
 	}
+
+	assert.Equal(t, 1, broker.NotifyPublishCallCount, "NotifyPublish must be registered once per channel, not once per publish")
 }
 
-type rabbitMQInMemoryBroker struct {
-	buffer chan amqp.Delivery
+func TestPublishReturnedWhenUnroutable(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:       testMetadataHostValue,
+			metadataConsumerIDKey: testMetadataConsumerIDValue,
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
 
-	connectCount int
-	closeCount   int
+	broker.NextPublishUnroutable = true
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{Topic: "mytopic", Data: []byte("hello world")})
+	// An unroutable mandatory publish is reported via NotifyReturn, not as a
+	// Publish error.
+	assert.Nil(t, err)
 }
 
-func (r *rabbitMQInMemoryBroker) Qos(prefetchCount, prefetchSize int, global bool) error {
-	return nil
+func TestPublishWithDelayedExchange(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:            testMetadataHostValue,
+			metadataConsumerIDKey:      testMetadataConsumerIDValue,
+			metadataUseDelayedExchange: "true",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{
+		Topic:    "mytopic",
+		Data:     []byte("hello world"),
+		Metadata: map[string]string{metadataDeliverAfter: "30s"},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, delayedExchangeKind, broker.LastExchangeKind)
+	assert.Equal(t, defaultDelayedExchangeType, broker.LastExchangeArgs[delayedExchangeTypeArg])
+	assert.Equal(t, int64(30000), broker.LastPublishHeaders[delayHeader])
 }
 
-func (r *rabbitMQInMemoryBroker) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
-	if string(msg.Body) == errorChannelConnection {
-		return errors.New(errorChannelConnection)
+func TestSubscribeQosAndPriority(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:          testMetadataHostValue,
+			metadataConsumerIDKey:    testMetadataConsumerIDValue,
+			metadataPrefetchCount:    "10",
+			metadataPrefetchSize:     "0",
+			metadataMaxPriority:      "5",
+			metadataConsumerPriority: "3",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+	if assert.NotEmpty(t, broker.QosCalls) {
+		assert.Equal(t, rabbitmqamqp.QosCall{PrefetchCount: 10, PrefetchSize: 0, Global: false}, broker.QosCalls[0])
 	}
 
-	r.buffer <- createAMQPMessage(msg.Body)
+	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: "prioritytopic"}, func(ctx context.Context, msg *pubsub.NewMessage) error {
+		return nil
+	})
+	assert.Nil(t, err)
 
-	return nil
+	assert.Equal(t, 5, broker.LastQueueDeclareArgs[argMaxPriority])
+	assert.Equal(t, 3, broker.LastConsumeArgs[argConsumerPriority])
+
+	err = pubsubRabbitMQ.Publish(&pubsub.PublishRequest{
+		Topic:    "prioritytopic",
+		Data:     []byte("hello world"),
+		Metadata: map[string]string{metadataPriority: "7"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(7), broker.LastPublishPriority)
 }
 
-func (r *rabbitMQInMemoryBroker) QueueDeclare(name string, durable bool, autoDelete bool, exclusive bool, noWait bool, args amqp.Table) (amqp.Queue, error) {
-	return amqp.Queue{Name: name}, nil
+// RecordingAcknowledger records the requeue flag of every Nack and the
+// number of Acks, so a test can tell a quarantining Nack(requeue=false)
+// apart from an ordinary retry, and a retry-queue Ack apart from either.
+type RecordingAcknowledger struct {
+	mu     sync.Mutex
+	nacked []bool
+	acked  int
 }
 
-func (r *rabbitMQInMemoryBroker) QueueBind(name string, key string, exchange string, noWait bool, args amqp.Table) error {
+func (a *RecordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	a.acked++
+	a.mu.Unlock()
+
 	return nil
 }
 
-func (r *rabbitMQInMemoryBroker) Consume(queue string, consumer string, autoAck bool, exclusive bool, noLocal bool, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
-	return r.buffer, nil
+func (a *RecordingAcknowledger) ackCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.acked
 }
 
-func (r *rabbitMQInMemoryBroker) Nack(tag uint64, multiple bool, requeue bool) error {
+func (a *RecordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	a.nacked = append(a.nacked, requeue)
+	a.mu.Unlock()
+
 	return nil
 }
 
-func (r *rabbitMQInMemoryBroker) Ack(tag uint64, multiple bool) error {
+func (a *RecordingAcknowledger) Reject(tag uint64, requeue bool) error {
 	return nil
 }
 
-func (r *rabbitMQInMemoryBroker) ExchangeDeclare(name string, kind string, durable bool, autoDelete bool, internal bool, noWait bool, args amqp.Table) error {
-	return nil
+func (a *RecordingAcknowledger) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.nacked)
 }
 
-func (r *rabbitMQInMemoryBroker) Close() error {
-	r.closeCount++
+func (a *RecordingAcknowledger) last() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	return nil
+	return a.nacked[len(a.nacked)-1]
+}
+
+func TestDeadLetterQuarantineAfterMaxAttempts(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:             testMetadataHostValue,
+			metadataConsumerIDKey:       testMetadataConsumerIDValue,
+			metadataEnableDeadLetter:    "true",
+			metadataMaxDeliveryAttempts: "2",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+
+	handler := func(ctx context.Context, msg *pubsub.NewMessage) error {
+		return errors.New("handler always fails")
+	}
+	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: "dlqtopic"}, handler)
+	assert.Nil(t, err)
+
+	// Simulate a message the broker has already dead-lettered once.
+	ack := &RecordingAcknowledger{}
+	broker.Buffer <- amqp.Delivery{
+		Body:         []byte("poison"),
+		Acknowledger: ack,
+		Headers: amqp.Table{
+			headerDeath: []interface{}{amqp.Table{headerDeathCount: int64(1)}},
+		},
+	}
+
+	assert.Eventually(t, func() bool { return ack.count() == 1 }, time.Second, 10*time.Millisecond)
+	assert.False(t, ack.last(), "a message failing its maxDeliveryAttempts-th delivery should be nacked without requeue")
+}
+
+// TestDeadLetterRetryWithoutExplicitTTL guards against a regression where an
+// in-budget failure only went through the retry queue (and so only
+// accumulated x-death) when retryTTL was explicitly configured; otherwise it
+// was nacked with requeue=true directly onto the same queue, where x-death
+// never accumulates and maxDeliveryAttempts is never reached.
+func TestDeadLetterRetryWithoutExplicitTTL(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:             testMetadataHostValue,
+			metadataConsumerIDKey:       testMetadataConsumerIDValue,
+			metadataEnableDeadLetter:    "true",
+			metadataMaxDeliveryAttempts: "5",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+
+	handler := func(ctx context.Context, msg *pubsub.NewMessage) error {
+		return errors.New("handler always fails")
+	}
+	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: "dlqtopic"}, handler)
+	assert.Nil(t, err)
+
+	// A fresh message with no x-death yet, well within maxDeliveryAttempts.
+	ack := &RecordingAcknowledger{}
+	broker.Buffer <- amqp.Delivery{
+		Body:         []byte("poison"),
+		Acknowledger: ack,
+	}
+
+	assert.Eventually(t, func() bool { return ack.ackCount() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, ack.count(), "an in-budget failure must go through the retry queue instead of a direct Nack, even without an explicit retryTTL")
+}
+
+// TestDeadLetterRetryRoutingKeyMatchesPrimaryBinding guards against a
+// regression where the retry queue's x-dead-letter-routing-key was left
+// unset: that only worked by accident on the default fanout exchange, which
+// ignores routing keys. On a topic/direct exchange, a message dead-lettered
+// from the retry queue would otherwise carry the retry queue's own name as
+// its routing key and fail to match the primary queue's binding, silently
+// dropping the retried message.
+func TestDeadLetterRetryRoutingKeyMatchesPrimaryBinding(t *testing.T) {
+	broker := newBroker()
+	pubsubRabbitMQ := newRabbitMQTest(broker)
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:          testMetadataHostValue,
+			metadataConsumerIDKey:    testMetadataConsumerIDValue,
+			metadataEnableDeadLetter: "true",
+			metadataExchangeKind:     "topic",
+		},
+	}
+	err := pubsubRabbitMQ.Init(metadata)
+	assert.Nil(t, err)
+
+	err = pubsubRabbitMQ.Subscribe(pubsub.SubscribeRequest{Topic: "dlqtopic"}, func(ctx context.Context, msg *pubsub.NewMessage) error {
+		return nil
+	})
+	assert.Nil(t, err)
+
+	queueName := fmt.Sprintf("dlqtopic-%s", testMetadataConsumerIDValue)
+	retryArgs := broker.QueueDeclareArgs[retryQueueName(queueName)]
+	if assert.NotNil(t, retryArgs) {
+		assert.Equal(t, "", retryArgs[argDeadLetterRoutingKey], "the retry queue's dead-letter routing key must match the primary queue's binding key")
+	}
+}
+
+func TestParseMetadataDriverSelection(t *testing.T) {
+	base := map[string]string{
+		metadataHostKey:       testMetadataHostValue,
+		metadataConsumerIDKey: testMetadataConsumerIDValue,
+	}
+
+	withProperty := func(key, value string) map[string]string {
+		m := make(map[string]string, len(base)+1)
+		for k, v := range base {
+			m[k] = v
+		}
+		m[key] = value
+
+		return m
+	}
+
+	meta, err := parseRabbitMQMetadata(pubsub.Metadata{Properties: base})
+	assert.Nil(t, err)
+	assert.Equal(t, rabbitmqamqp.DriverAMQP091, meta.driver, "amqp091 is the default driver")
+
+	meta, err = parseRabbitMQMetadata(pubsub.Metadata{Properties: withProperty(metadataProtocolVersion, protocolVersion10)})
+	assert.Nil(t, err)
+	assert.Equal(t, rabbitmqamqp.DriverQpidProton, meta.driver, "protocolVersion 1.0 selects the qpid-proton driver")
+
+	meta, err = parseRabbitMQMetadata(pubsub.Metadata{Properties: withProperty(metadataDriver, rabbitmqamqp.DriverStreadway)})
+	assert.Nil(t, err)
+	assert.Equal(t, rabbitmqamqp.DriverStreadway, meta.driver, "driver overrides the protocolVersion default")
+}
+
+func TestInitResolvesRegisteredDriverWhenNoConnectionDialIsInjected(t *testing.T) {
+	r := &rabbitMQ{
+		declaredExchanges: make(map[string]bool),
+		logger:            logger.NewLogger("test"),
+	}
+
+	metadata := pubsub.Metadata{
+		Properties: map[string]string{
+			metadataHostKey:       testMetadataHostValue,
+			metadataConsumerIDKey: testMetadataConsumerIDValue,
+			metadataDriver:        "not-a-registered-driver",
+		},
+	}
+
+	err := r.Init(metadata)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no transport registered")
 }
@@ -1,31 +1,118 @@
 package shared
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
+const defaultMaxErrors = 16
+
+// ErrorCollection accumulates errors from concurrent producers (e.g. a
+// reconnect loop) and lets a consumer wait for, drain, and reset them
+// without racing on repeated channel closes or copying the embedded mutex.
 type ErrorCollection struct {
+	mu        sync.Mutex
 	errors    []error
-	mux       sync.Mutex
-	ErrNotify chan struct{}
+	notify    chan struct{}
+	maxErrors int
+	ctx       context.Context
 }
 
-func NewErrorCollection() ErrorCollection {
-	return ErrorCollection{
-		errors:    []error{},
-		ErrNotify: make(chan struct{}),
+// NewErrorCollection returns a ready-to-use ErrorCollection with a default
+// capacity; oldest errors are dropped once that capacity is exceeded.
+func NewErrorCollection() *ErrorCollection {
+	return &ErrorCollection{
+		notify:    make(chan struct{}),
+		maxErrors: defaultMaxErrors,
 	}
 }
 
+// WithContext binds the collection to ctx: once ctx is done, Wait's channel
+// closes so callers blocked on it don't leak past the collection's lifetime.
+func (c *ErrorCollection) WithContext(ctx context.Context) *ErrorCollection {
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+
+	if ctx != nil {
+		go func() {
+			<-ctx.Done()
+
+			c.mu.Lock()
+			c.closeNotifyLocked()
+			c.mu.Unlock()
+		}()
+	}
+
+	return c
+}
+
+// Append records e, dropping the oldest error first if the collection is at
+// capacity, and wakes any goroutine blocked on Wait.
 func (c *ErrorCollection) Append(e error) {
-	c.mux.Lock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if len(c.errors) == 0 {
-		close(c.ErrNotify)
+		c.closeNotifyLocked()
+	}
+
+	if c.maxErrors > 0 && len(c.errors) >= c.maxErrors {
+		c.errors = c.errors[1:]
 	}
 	c.errors = append(c.errors, e)
-	c.mux.Unlock()
 }
 
-func (c *ErrorCollection) GetErrors() []error {
-	c.mux.Lock()
-	defer c.mux.Unlock()
-	return c.errors
+// Wait returns a channel that closes once at least one error has been
+// appended since the last Drain. Each call returns the channel for the
+// current generation, so it is always safe to read even across Drains.
+func (c *ErrorCollection) Wait() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errors) > 0 {
+		closed := make(chan struct{})
+		close(closed)
+
+		return closed
+	}
+
+	return c.notify
+}
+
+// Drain atomically returns and clears the accumulated errors and arms a
+// fresh notification channel for the next Append. If the bound context is
+// already done, notify is left as-is (already closed by WithContext's
+// watcher) instead of being replaced, since nothing will ever close a
+// freshly made channel once that watcher has already fired.
+func (c *ErrorCollection) Drain() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := c.errors
+	c.errors = nil
+
+	if c.ctx == nil || c.ctx.Err() == nil {
+		c.notify = make(chan struct{})
+	}
+
+	return errs
+}
+
+// Len reports how many errors are currently accumulated.
+func (c *ErrorCollection) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.errors)
+}
+
+// closeNotifyLocked closes the current notify channel; callers must hold mu.
+func (c *ErrorCollection) closeNotifyLocked() {
+	select {
+	case <-c.notify:
+		// Already closed (e.g. by a prior ctx cancellation).
+	default:
+		close(c.notify)
+	}
 }
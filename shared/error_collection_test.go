@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCollectionAppendAndDrain(t *testing.T) {
+	ec := NewErrorCollection()
+
+	assert.Equal(t, 0, ec.Len())
+
+	ec.Append(errors.New("boom1"))
+	ec.Append(errors.New("boom2"))
+	assert.Equal(t, 2, ec.Len())
+
+	errs := ec.Drain()
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "boom1", errs[0].Error())
+	assert.Equal(t, "boom2", errs[1].Error())
+	assert.Equal(t, 0, ec.Len())
+}
+
+func TestErrorCollectionWaitUnblocksOnAppend(t *testing.T) {
+	ec := NewErrorCollection()
+
+	select {
+	case <-ec.Wait():
+		t.Fatal("Wait must not be ready before any error is appended")
+	default:
+	}
+
+	ec.Append(errors.New("boom"))
+
+	select {
+	case <-ec.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Wait should have unblocked after Append")
+	}
+}
+
+func TestErrorCollectionMaxErrorsEviction(t *testing.T) {
+	ec := NewErrorCollection()
+	ec.maxErrors = 2
+
+	ec.Append(errors.New("first"))
+	ec.Append(errors.New("second"))
+	ec.Append(errors.New("third"))
+
+	errs := ec.Drain()
+	if assert.Len(t, errs, 2) {
+		assert.Equal(t, "second", errs[0].Error())
+		assert.Equal(t, "third", errs[1].Error())
+	}
+}
+
+func TestErrorCollectionWithContextClosesWaitOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ec := NewErrorCollection().WithContext(ctx)
+
+	waitCh := ec.Wait()
+	select {
+	case <-waitCh:
+		t.Fatal("Wait must not be ready before ctx is cancelled or an error is appended")
+	default:
+	}
+
+	cancel()
+
+	select {
+	case <-waitCh:
+	case <-time.After(time.Second):
+		t.Fatal("Wait should close once ctx is done")
+	}
+}
+
+// TestErrorCollectionDrainAfterContextDoneDoesNotReleak guards against a
+// regression where Drain unconditionally armed a fresh notify channel, even
+// when the bound context was already done: the next Wait() call then
+// returned that fresh, never-to-be-closed channel and blocked forever, since
+// the context-cancellation watcher had already fired and exited.
+func TestErrorCollectionDrainAfterContextDoneDoesNotReleak(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ec := NewErrorCollection().WithContext(ctx)
+
+	cancel()
+
+	select {
+	case <-ec.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Wait should close once ctx is done")
+	}
+
+	ec.Drain()
+
+	select {
+	case <-ec.Wait():
+	case <-time.After(time.Second):
+		t.Fatal("Wait must stay closed after a post-cancellation Drain, not leak a fresh channel")
+	}
+}